@@ -0,0 +1,208 @@
+// Package auth authenticates driver and monitor connections with JWTs (or,
+// for local development, a static on-disk token list), and authorizes what
+// a given token is allowed to do: a driver token must match the driver_id
+// it connects as, and a monitor token may be scoped to a subset of drivers.
+package auth
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RoleMonitor is the expected "role" claim for monitor connections. Driver
+// connections carry no role claim (or any role other than "monitor").
+const RoleMonitor = "monitor"
+
+// Claims is the subset of a validated token's claims this service cares
+// about.
+type Claims struct {
+	Subject        string   `json:"sub"`
+	DriverID       string   `json:"driver_id"`
+	Role           string   `json:"role"`
+	AllowedDrivers []string `json:"allowed_drivers"`
+}
+
+// jwtClaims is the wire shape used for HS256/RS256 verification. The static
+// dev token file decodes straight into Claims, whose JSON field names match.
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	DriverID       string   `json:"driver_id"`
+	Role           string   `json:"role"`
+	AllowedDrivers []string `json:"allowed_drivers"`
+}
+
+// Authenticator validates bearer tokens. A zero-value-ish Authenticator
+// (constructed by New with no secret, JWKS URL, or static token file) is
+// disabled: Authenticate always succeeds with an unrestricted Claims, which
+// preserves the service's pre-auth open behavior.
+type Authenticator struct {
+	enabled      bool
+	hmacSecret   []byte
+	jwksURL      string
+	jwksKeys     map[string]*rsa.PublicKey
+	staticTokens map[string]Claims
+}
+
+// Option configures an Authenticator constructed by New.
+type Option func(*Authenticator) error
+
+// WithHMACSecret enables HS256 verification using secret.
+func WithHMACSecret(secret string) Option {
+	return func(a *Authenticator) error {
+		if secret == "" {
+			return nil
+		}
+		a.enabled = true
+		a.hmacSecret = []byte(secret)
+		return nil
+	}
+}
+
+// WithJWKSURL enables RS256 verification, fetching signing keys from url.
+func WithJWKSURL(url string) Option {
+	return func(a *Authenticator) error {
+		if url == "" {
+			return nil
+		}
+		keys, err := fetchJWKS(url)
+		if err != nil {
+			return fmt.Errorf("auth: fetching JWKS from %s: %w", url, err)
+		}
+		a.enabled = true
+		a.jwksURL = url
+		a.jwksKeys = keys
+		return nil
+	}
+}
+
+// WithStaticTokenFile enables a dev-mode static token list: a newline
+// delimited file of "<token> <json claims>" pairs, checked before any JWT
+// verification. Intended for local development only.
+func WithStaticTokenFile(path string) Option {
+	return func(a *Authenticator) error {
+		if path == "" {
+			return nil
+		}
+		tokens, err := loadStaticTokens(path)
+		if err != nil {
+			return fmt.Errorf("auth: loading static token file %s: %w", path, err)
+		}
+		a.enabled = true
+		a.staticTokens = tokens
+		return nil
+	}
+}
+
+// New builds an Authenticator from the given options. With no options (or
+// all options no-ops, e.g. empty strings), the returned Authenticator is
+// disabled and Authenticate always succeeds.
+func New(opts ...Option) (*Authenticator, error) {
+	a := &Authenticator{}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Enabled reports whether any authentication method was configured.
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// Authenticate extracts and validates a bearer token from r (the "token"
+// query parameter, or an "Authorization: Bearer <token>" header), returning
+// its claims. If the authenticator is disabled, it returns an empty Claims
+// and no error, preserving open (no-auth) behavior.
+func (a *Authenticator) Authenticate(r *http.Request) (Claims, error) {
+	if !a.enabled {
+		return Claims{}, nil
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		h := r.Header.Get("Authorization")
+		if strings.HasPrefix(h, "Bearer ") {
+			token = strings.TrimPrefix(h, "Bearer ")
+		}
+	}
+	if token == "" {
+		return Claims{}, fmt.Errorf("auth: no bearer token provided")
+	}
+
+	if claims, ok := a.staticTokens[token]; ok {
+		return claims, nil
+	}
+
+	claims, err := a.verifyJWT(token)
+	if err != nil {
+		return Claims{}, err
+	}
+	return claims, nil
+}
+
+func (a *Authenticator) verifyJWT(raw string) (Claims, error) {
+	parsed, err := jwt.ParseWithClaims(raw, &jwtClaims{}, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if a.hmacSecret == nil {
+				return nil, fmt.Errorf("HS256 not configured")
+			}
+			return a.hmacSecret, nil
+		case *jwt.SigningMethodRSA:
+			kid, _ := t.Header["kid"].(string)
+			key, ok := a.jwksKeys[kid]
+			if !ok {
+				return nil, fmt.Errorf("unknown key id %q", kid)
+			}
+			return key, nil
+		default:
+			return nil, fmt.Errorf("unsupported signing method %v", t.Header["alg"])
+		}
+	})
+	if err != nil {
+		return Claims{}, fmt.Errorf("auth: invalid token: %w", err)
+	}
+	jc, ok := parsed.Claims.(*jwtClaims)
+	if !ok || !parsed.Valid {
+		return Claims{}, fmt.Errorf("auth: invalid token claims")
+	}
+	if jc.ExpiresAt != nil && jc.ExpiresAt.Before(time.Now()) {
+		return Claims{}, fmt.Errorf("auth: token expired")
+	}
+	return Claims{
+		Subject:        jc.Subject,
+		DriverID:       jc.DriverID,
+		Role:           jc.Role,
+		AllowedDrivers: jc.AllowedDrivers,
+	}, nil
+}
+
+// AuthorizeDriver reports whether claims permit connecting/posting as
+// driverID: the sub claim or the custom driver_id claim must match.
+func (a *Authenticator) AuthorizeDriver(claims Claims, driverID string) error {
+	if !a.enabled {
+		return nil
+	}
+	if claims.DriverID == driverID || claims.Subject == driverID {
+		return nil
+	}
+	return fmt.Errorf("auth: token is not authorized for driver %q", driverID)
+}
+
+// AuthorizeMonitor reports whether claims permit a monitor connection.
+func (a *Authenticator) AuthorizeMonitor(claims Claims) error {
+	if !a.enabled {
+		return nil
+	}
+	if claims.Role != RoleMonitor {
+		return fmt.Errorf("auth: token does not have the monitor role")
+	}
+	return nil
+}
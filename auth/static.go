@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadStaticTokens reads a dev-mode token file: one token per line, of the
+// form "<token> <json-encoded Claims>", blank lines and lines starting with
+// "#" ignored. Modeled on the static-tokens mode used by other proxies for
+// local development without a real identity provider.
+func loadStaticTokens(path string) (map[string]Claims, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := make(map[string]Claims)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		token, rest, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"<token> <json claims>\"", lineNo)
+		}
+		var claims Claims
+		if err := json.Unmarshal([]byte(strings.TrimSpace(rest)), &claims); err != nil {
+			return nil, fmt.Errorf("line %d: invalid claims json: %w", lineNo, err)
+		}
+		tokens[token] = claims
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
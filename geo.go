@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// earthRadiusMeters is used by haversine; a mean spherical Earth radius is
+// plenty accurate for "is this driver roughly in the viewport" filtering.
+const earthRadiusMeters = 6371000.0
+
+// bbox is an inclusive latitude/longitude bounding box.
+type bbox struct {
+	MinLat, MinLng, MaxLat, MaxLng float64
+}
+
+func (b bbox) contains(lat, lng float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lng >= b.MinLng && lng <= b.MaxLng
+}
+
+// nearFilter matches locations within RadiusMeters of (Lat, Lng).
+type nearFilter struct {
+	Lat, Lng     float64
+	RadiusMeters float64
+}
+
+func (n nearFilter) contains(lat, lng float64) bool {
+	return haversineMeters(n.Lat, n.Lng, lat, lng) <= n.RadiusMeters
+}
+
+// haversineMeters returns the great-circle distance between two
+// lat/lng points, in meters.
+func haversineMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+	return earthRadiusMeters * c
+}
+
+// monitorFilter is what a single monitor connection is subscribed to: a
+// single driver, a bounding box, a center+radius, or (zero value) the full
+// firehose. At most one of DriverID/BBox/Near is set.
+type monitorFilter struct {
+	DriverID string
+	BBox     *bbox
+	Near     *nearFilter
+}
+
+// matches reports whether loc should be delivered to a monitor holding this
+// filter.
+func (f monitorFilter) matches(loc Location) bool {
+	switch {
+	case f.DriverID != "":
+		return f.DriverID == loc.DriverID
+	case f.BBox != nil:
+		return f.BBox.contains(loc.Lat, loc.Lng)
+	case f.Near != nil:
+		return f.Near.contains(loc.Lat, loc.Lng)
+	default:
+		return true
+	}
+}
+
+// isZero reports whether f carries no filter at all (the full firehose).
+func (f monitorFilter) isZero() bool {
+	return f.DriverID == "" && f.BBox == nil && f.Near == nil
+}
+
+// parseBBox parses "minLat,minLng,maxLat,maxLng" as used by the ?bbox= query
+// param and the subscribe_bbox: wire command.
+func parseBBox(raw string) (bbox, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		return bbox{}, fmt.Errorf("bbox must have 4 comma-separated values, got %d", len(parts))
+	}
+	vals := make([]float64, 4)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return bbox{}, fmt.Errorf("bbox value %q: %w", p, err)
+		}
+		vals[i] = v
+	}
+	return bbox{MinLat: vals[0], MinLng: vals[1], MaxLat: vals[2], MaxLng: vals[3]}, nil
+}
+
+// parseNear parses "lat,lng,radiusMeters" as used by the ?near= query param
+// and the subscribe_near: wire command.
+func parseNear(raw string) (nearFilter, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != 3 {
+		return nearFilter{}, fmt.Errorf("near must have 3 comma-separated values, got %d", len(parts))
+	}
+	vals := make([]float64, 3)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nearFilter{}, fmt.Errorf("near value %q: %w", p, err)
+		}
+		vals[i] = v
+	}
+	return nearFilter{Lat: vals[0], Lng: vals[1], RadiusMeters: vals[2]}, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestMonitorFilterMatches(t *testing.T) {
+	loc := Location{DriverID: "d1", Lat: 10, Lng: 20}
+
+	cases := []struct {
+		name   string
+		filter monitorFilter
+		want   bool
+	}{
+		{"zero value matches everything", monitorFilter{}, true},
+		{"matching driver id", monitorFilter{DriverID: "d1"}, true},
+		{"non-matching driver id", monitorFilter{DriverID: "d2"}, false},
+		{"bbox containing point", monitorFilter{BBox: &bbox{MinLat: 0, MinLng: 0, MaxLat: 15, MaxLng: 25}}, true},
+		{"bbox excluding point", monitorFilter{BBox: &bbox{MinLat: 0, MinLng: 0, MaxLat: 5, MaxLng: 5}}, false},
+		{"near within radius", monitorFilter{Near: &nearFilter{Lat: 10, Lng: 20, RadiusMeters: 1000}}, true},
+		{"near outside radius", monitorFilter{Near: &nearFilter{Lat: 10, Lng: 20.1, RadiusMeters: 1}}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.matches(loc); got != tc.want {
+				t.Errorf("matches() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMonitorFilterIsZero(t *testing.T) {
+	if !(monitorFilter{}).isZero() {
+		t.Error("expected zero-value monitorFilter to be isZero")
+	}
+	if (monitorFilter{DriverID: "d1"}).isZero() {
+		t.Error("expected a driver-scoped monitorFilter not to be isZero")
+	}
+}
+
+func TestHaversineMeters(t *testing.T) {
+	if d := haversineMeters(0, 0, 0, 0); d != 0 {
+		t.Errorf("distance between identical points = %v, want 0", d)
+	}
+	// Roughly 111km per degree of latitude near the equator.
+	d := haversineMeters(0, 0, 1, 0)
+	if d < 110000 || d > 112000 {
+		t.Errorf("distance for 1 degree of latitude = %v, want ~111000m", d)
+	}
+}
+
+func TestParseBBoxAndNear(t *testing.T) {
+	b, err := parseBBox("1,2,3,4")
+	if err != nil {
+		t.Fatalf("parseBBox: %v", err)
+	}
+	want := bbox{MinLat: 1, MinLng: 2, MaxLat: 3, MaxLng: 4}
+	if b != want {
+		t.Errorf("parseBBox(%q) = %+v, want %+v", "1,2,3,4", b, want)
+	}
+	if _, err := parseBBox("1,2,3"); err == nil {
+		t.Error("parseBBox with 3 values: expected an error, got nil")
+	}
+
+	n, err := parseNear("1,2,3")
+	if err != nil {
+		t.Fatalf("parseNear: %v", err)
+	}
+	wantNear := nearFilter{Lat: 1, Lng: 2, RadiusMeters: 3}
+	if n != wantNear {
+		t.Errorf("parseNear(%q) = %+v, want %+v", "1,2,3", n, wantNear)
+	}
+	if _, err := parseNear("1,2"); err == nil {
+		t.Error("parseNear with 2 values: expected an error, got nil")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is the time allowed to write a message (or ping) to a peer.
+	writeWait = 10 * time.Second
+
+	// pongWait is how long to wait for a pong before considering a
+	// connection dead. pingPeriod must be less than pongWait.
+	pongWait = 60 * time.Second
+
+	// pingPeriod is how often a ping is sent to keep the connection alive
+	// and detect dead peers.
+	pingPeriod = 54 * time.Second
+
+	// sendBufferSize bounds how many outbound messages can queue for a
+	// single client before it is considered slow and disconnected.
+	sendBufferSize = 256
+)
+
+// monitorSink is anything that can receive broadcast location updates
+// through its own bounded, non-blocking outbound queue: a monitor
+// websocket (*client) or an SSE connection (*sseClient). The broadcaster
+// fans out to both kinds identically.
+type monitorSink interface {
+	tryWrite(msg []byte) bool
+	tryWriteLocation(loc Location, msg []byte) bool
+	allowsDriver(driverID string) bool
+	queueDepth() int
+	droppedCount() uint64
+	closeSend()
+}
+
+// client wraps a websocket connection with its own outbound queue and
+// writer goroutine, so one slow reader can never block delivery to anyone
+// else. Both driver and monitor connections use it.
+type client struct {
+	ws             *websocket.Conn
+	send           chan []byte
+	driverID       string   // non-empty for driver connections
+	allowedDrivers []string // non-nil for monitors scoped by a token's allowed_drivers claim
+	closeOnce      sync.Once
+
+	dropped uint64 // atomic: messages dropped because send was full
+}
+
+// allowsDriver reports whether this client may see updates for driverID.
+// A nil allowedDrivers means no restriction (the default, open-mode client).
+func (c *client) allowsDriver(driverID string) bool {
+	return allowedDriversContain(c.allowedDrivers, driverID)
+}
+
+// allowedDriversContain reports whether driverID is in allowed, treating a
+// nil allowed list as "no restriction".
+func allowedDriversContain(allowed []string, driverID string) bool {
+	if allowed == nil {
+		return true
+	}
+	for _, id := range allowed {
+		if id == driverID {
+			return true
+		}
+	}
+	return false
+}
+
+// closeSend closes the outbound channel at most once, so writePump exits
+// cleanly however the client came to be removed.
+func (c *client) closeSend() {
+	c.closeOnce.Do(func() { close(c.send) })
+}
+
+func newClient(ws *websocket.Conn, driverID string) *client {
+	return &client{
+		ws:       ws,
+		send:     make(chan []byte, sendBufferSize),
+		driverID: driverID,
+	}
+}
+
+// queueDepth reports how many messages are currently buffered for this
+// client, for metrics.
+func (c *client) queueDepth() int {
+	return len(c.send)
+}
+
+// droppedCount reports how many messages have been dropped for this
+// client because its outbound buffer was full.
+func (c *client) droppedCount() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// tryWrite enqueues msg without blocking. It reports false if the client's
+// buffer is full, in which case the caller should disconnect the client
+// rather than stall the broadcaster.
+func (c *client) tryWrite(msg []byte) bool {
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+		return false
+	}
+}
+
+// tryWriteLocation delivers a broadcast location update. A plain websocket
+// client has no use for the location's WAL sequence number, so it just
+// writes the already-marshaled msg as-is.
+func (c *client) tryWriteLocation(loc Location, msg []byte) bool {
+	return c.tryWrite(msg)
+}
+
+// writePump owns all writes to c.ws: queued messages and periodic pings. It
+// must run in its own goroutine, one per client, and returns (closing the
+// connection) when send is closed or a write fails.
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				_ = c.ws.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// configureKeepalive sets the initial read deadline and pong handler that
+// keep ws's deadline pushed out as long as pongs keep arriving. Call before
+// starting a connection's read loop.
+func configureKeepalive(ws *websocket.Conn) {
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		ws.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+}
+
+// closeSlow disconnects a sink whose outbound buffer is full, logging the
+// drop rather than letting the broadcaster block on it.
+func closeSlow(kind string, c monitorSink) {
+	log.Printf("%s send buffer full, disconnecting (dropped=%d)", kind, c.droppedCount())
+	c.closeSend()
+}
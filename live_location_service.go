@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
@@ -16,7 +18,10 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
-	"github.com/nats-io/nats.go"
+
+	"panaqet/auth"
+	"panaqet/messaging"
+	"panaqet/wal"
 )
 
 // Location is the canonical message for location updates.
@@ -28,80 +33,148 @@ type Location struct {
 	TS       float64                `json:"ts"`
 	Meta     map[string]interface{} `json:"meta,omitempty"`
 
-	// internal bookkeeping field (not serialized to clients)
+	// internal bookkeeping fields (not serialized to clients)
 	_receivedAt float64 `json:"-"`
+	_fromPeer   bool    `json:"-"` // arrived via the broker rather than a local driver conn
+	_seq        uint64  `json:"-"` // WAL sequence number, set when durable mode is on
+}
+
+// toWireLocation strips internal bookkeeping fields before handing a
+// Location to the messaging package.
+func toWireLocation(loc Location) messaging.Location {
+	return messaging.Location{
+		Type:     loc.Type,
+		DriverID: loc.DriverID,
+		Lat:      loc.Lat,
+		Lng:      loc.Lng,
+		TS:       loc.TS,
+		Meta:     loc.Meta,
+		Origin:   messaging.InstanceID(),
+	}
+}
+
+// fromWireLocation converts a broker message back into a Location, marking
+// it as peer-originated so it isn't re-published.
+func fromWireLocation(loc messaging.Location) Location {
+	return Location{
+		Type:      loc.Type,
+		DriverID:  loc.DriverID,
+		Lat:       loc.Lat,
+		Lng:       loc.Lng,
+		TS:        loc.TS,
+		Meta:      loc.Meta,
+		_fromPeer: true,
+	}
 }
 
 // Manager holds websocket connections and last-known locations.
 type Manager struct {
-	drivers       map[string]*websocket.Conn // driver_id -> websocket (optional)
-	monitors      map[*websocket.Conn]bool   // set of monitor websockets
-	monitorSubs   map[*websocket.Conn]string // option subscription driver_id (empty => all)
-	lastKnown     map[string]Location
-	mu            sync.RWMutex
-	natsConn      *nats.Conn
-	natsSubject   string
-	natsEnabled   bool
-	broadcastChan chan Location
+	drivers          map[string]*client            // driver_id -> driver client (optional)
+	monitors         map[monitorSink]bool          // set of monitor sinks (WS or SSE)
+	monitorSubs      map[monitorSink]monitorFilter // per-monitor filter (zero value => all)
+	monitorReplaying map[monitorSink]*replayBuffer // monitors mid-resume-replay; see beginReplay
+	lastKnown        map[string]Location
+	mu               sync.RWMutex
+	broker           *messaging.Broker
+	brokerSubject    string
+	broadcastChan    chan Location
+	wal              *wal.WAL            // nil unless durable mode is enabled
+	auth             *auth.Authenticator // must be set before serving; a disabled one preserves today's open behavior
 }
 
 func NewManager() *Manager {
 	return &Manager{
-		drivers:       make(map[string]*websocket.Conn),
-		monitors:      make(map[*websocket.Conn]bool),
-		monitorSubs:   make(map[*websocket.Conn]string),
-		lastKnown:     make(map[string]Location),
-		broadcastChan: make(chan Location, 1024),
+		drivers:          make(map[string]*client),
+		monitors:         make(map[monitorSink]bool),
+		monitorSubs:      make(map[monitorSink]monitorFilter),
+		monitorReplaying: make(map[monitorSink]*replayBuffer),
+		lastKnown:        make(map[string]Location),
+		broadcastChan:    make(chan Location, 1024),
 	}
 }
 
-// SetNATS configures NATS publishing
-func (m *Manager) SetNATS(nc *nats.Conn, subject string) {
-	if nc == nil {
-		m.natsEnabled = false
-		return
-	}
-	m.natsEnabled = true
-	m.natsConn = nc
-	m.natsSubject = subject
+// SetWAL enables durable mode: every accepted location is appended to w
+// before it is fanned out, and history/resume reads come from w.
+func (m *Manager) SetWAL(w *wal.WAL) {
+	m.wal = w
+}
+
+// SetAuthenticator enables token authentication/authorization on the
+// driver and monitor endpoints. An authenticator with no method configured
+// (auth.New with no options) leaves today's open behavior unchanged.
+func (m *Manager) SetAuthenticator(a *auth.Authenticator) {
+	m.auth = a
+}
+
+// SetBroker wires up the pub/sub backend used to publish accepted locations
+// and to receive rebroadcasts from other instances in the fleet. subject is
+// the topic/subject used for both directions.
+func (m *Manager) SetBroker(broker *messaging.Broker, subject string) error {
+	m.broker = broker
+	m.brokerSubject = subject
+	return broker.Subscribe(subject, func(loc messaging.Location) {
+		if loc.Origin == messaging.InstanceID() {
+			// Our own publish looped back from the broker; receiveFromDriver
+			// already fanned it out locally, so processing it again here
+			// would deliver it to monitors twice (and double-append it to
+			// the WAL in durable mode).
+			return
+		}
+		m.receiveFromPeer(fromWireLocation(loc))
+	})
 }
 
-// Run broadcaster loop (non-blocking) to send updates to monitors and optionally publish to NATS.
+// Run broadcaster loop (non-blocking) to send updates to monitors and
+// optionally publish to the configured broker for other instances to pick
+// up.
 func (m *Manager) RunBroadcaster(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case loc := <-m.broadcastChan:
-			// publish to NATS (best-effort)
-			if m.natsEnabled && m.natsConn != nil {
-				b, _ := json.Marshal(loc)
-				_ = m.natsConn.Publish(m.natsSubject, b) // ignore error: non-fatal
+			// publish to the broker (best-effort) so peer instances can
+			// rebroadcast to their own locally-connected monitors
+			if m.broker != nil && !loc._fromPeer {
+				_ = m.broker.Publish(m.brokerSubject, toWireLocation(loc)) // ignore error: non-fatal
 			}
 
 			// send to monitors (concurrent-safe)
 			m.mu.RLock()
-			monitors := make([]*websocket.Conn, 0, len(m.monitors))
-			subs := make(map[*websocket.Conn]string, len(m.monitorSubs))
-			for ws := range m.monitors {
-				monitors = append(monitors, ws)
+			monitors := make([]monitorSink, 0, len(m.monitors))
+			subs := make(map[monitorSink]monitorFilter, len(m.monitorSubs))
+			for c := range m.monitors {
+				monitors = append(monitors, c)
 			}
-			for ws, sub := range m.monitorSubs {
-				subs[ws] = sub
+			for c, sub := range m.monitorSubs {
+				subs[c] = sub
 			}
 			m.mu.RUnlock()
 
 			msg, _ := json.Marshal(loc)
-			for _, ws := range monitors {
-				// if this ws has a subscription, check
-				if subID, ok := subs[ws]; ok && subID != "" && subID != loc.DriverID {
+			for _, c := range monitors {
+				// if this client has a filter, it must match
+				if filter, ok := subs[c]; ok && !filter.matches(loc) {
 					continue
 				}
-				// write with a small timeout
-				ws.SetWriteDeadline(time.Now().Add(3 * time.Second))
-				if err := ws.WriteMessage(websocket.TextMessage, msg); err != nil {
-					// remove dead monitor
-					m.removeMonitor(ws)
+				// a token-scoped monitor never sees drivers outside its
+				// allowed_drivers claim, regardless of its current filter
+				if !c.allowsDriver(loc.DriverID) {
+					continue
+				}
+				// a monitor that is still catching up on a resume replay is
+				// registered already (so this update is never lost) but must
+				// not see it until the replay has finished writing older
+				// records; buffer it instead and let endReplay flush it in
+				// order once the replay completes
+				if m.bufferIfReplaying(c, loc, msg) {
+					continue
+				}
+				// non-blocking: a slow monitor is disconnected rather than
+				// stalling delivery to everyone else
+				if !c.tryWriteLocation(loc, msg) {
+					closeSlow("monitor", c)
+					m.removeMonitor(c)
 				}
 			}
 		}
@@ -120,6 +193,23 @@ func (m *Manager) receiveFromDriver(loc Location) {
 		loc.Type = "location"
 	}
 
+	// durable mode: persist before enqueueing for broadcast, so a fix never
+	// reaches a monitor without first being recoverable after a restart
+	if m.wal != nil {
+		rec, err := m.wal.Append(wal.Record{
+			DriverID: loc.DriverID,
+			Lat:      loc.Lat,
+			Lng:      loc.Lng,
+			TS:       loc.TS,
+			Meta:     loc.Meta,
+		})
+		if err != nil {
+			log.Printf("wal append failed for driver %s, dropping update: %v", loc.DriverID, err)
+			return
+		}
+		loc._seq = rec.Seq
+	}
+
 	m.mu.Lock()
 	m.lastKnown[loc.DriverID] = loc
 	m.mu.Unlock()
@@ -133,6 +223,37 @@ func (m *Manager) receiveFromDriver(loc Location) {
 	}
 }
 
+// receiveFromPeer handles a location rebroadcast from another instance in
+// the fleet. It updates last-known and fans out to this instance's locally
+// connected monitors, but (via loc._fromPeer) is never re-published to the
+// broker.
+func (m *Manager) receiveFromPeer(loc Location) {
+	if m.wal != nil {
+		rec, err := m.wal.Append(wal.Record{
+			DriverID: loc.DriverID,
+			Lat:      loc.Lat,
+			Lng:      loc.Lng,
+			TS:       loc.TS,
+			Meta:     loc.Meta,
+		})
+		if err != nil {
+			log.Printf("wal append failed for peer update (driver %s): %v", loc.DriverID, err)
+		} else {
+			loc._seq = rec.Seq
+		}
+	}
+
+	m.mu.Lock()
+	m.lastKnown[loc.DriverID] = loc
+	m.mu.Unlock()
+
+	select {
+	case m.broadcastChan <- loc:
+	default:
+		log.Printf("broadcast channel full, dropped peer location for driver %s", loc.DriverID)
+	}
+}
+
 func (m *Manager) getLastKnown(driverID string) (Location, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -150,40 +271,107 @@ func (m *Manager) getAllLastKnown() map[string]Location {
 	return out
 }
 
-func (m *Manager) addDriverConn(driverID string, ws *websocket.Conn) {
+func (m *Manager) addDriverConn(driverID string, c *client) {
 	m.mu.Lock()
-	m.drivers[driverID] = ws
+	m.drivers[driverID] = c
 	m.mu.Unlock()
 }
 
 func (m *Manager) removeDriverConn(driverID string) {
 	m.mu.Lock()
-	if ws, ok := m.drivers[driverID]; ok {
-		ws.Close()
+	if c, ok := m.drivers[driverID]; ok {
+		c.closeSend()
 		delete(m.drivers, driverID)
 	}
 	m.mu.Unlock()
 }
 
-func (m *Manager) addMonitor(ws *websocket.Conn, sub string) {
+func (m *Manager) addMonitor(c monitorSink, filter monitorFilter) {
 	m.mu.Lock()
-	m.monitors[ws] = true
-	if sub != "" {
-		m.monitorSubs[ws] = sub
+	m.monitors[c] = true
+	if !filter.isZero() {
+		m.monitorSubs[c] = filter
 	}
 	m.mu.Unlock()
 }
 
-func (m *Manager) removeMonitor(ws *websocket.Conn) {
+func (m *Manager) removeMonitor(c monitorSink) {
 	m.mu.Lock()
-	if _, ok := m.monitors[ws]; ok {
-		delete(m.monitors, ws)
+	if _, ok := m.monitors[c]; ok {
+		delete(m.monitors, c)
 	}
-	if _, ok := m.monitorSubs[ws]; ok {
-		delete(m.monitorSubs, ws)
+	if _, ok := m.monitorSubs[c]; ok {
+		delete(m.monitorSubs, c)
+	}
+	delete(m.monitorReplaying, c)
+	m.mu.Unlock()
+	c.closeSend()
+}
+
+// replayBuffer holds live broadcaster deliveries for a monitor that is
+// registered but still catching up on a resume replay (see beginReplay).
+type replayBuffer struct {
+	mu    sync.Mutex
+	items []replayBufferedLocation
+}
+
+type replayBufferedLocation struct {
+	loc Location
+	msg []byte
+}
+
+// beginReplay registers c as mid-replay so RunBroadcaster buffers its live
+// deliveries instead of writing them straight to c, and returns the buffer
+// to pass to endReplay once the replay scan finishes. Call it before
+// addMonitor so no update appended after the scan starts can be missed by
+// both the replay and the live path.
+func (m *Manager) beginReplay(c monitorSink) *replayBuffer {
+	buf := &replayBuffer{}
+	m.mu.Lock()
+	m.monitorReplaying[c] = buf
+	m.mu.Unlock()
+	return buf
+}
+
+// bufferIfReplaying appends loc/msg to c's replay buffer and reports true if
+// c is currently mid-replay; otherwise it does nothing and reports false so
+// the caller delivers the update as usual.
+func (m *Manager) bufferIfReplaying(c monitorSink, loc Location, msg []byte) bool {
+	m.mu.RLock()
+	buf, ok := m.monitorReplaying[c]
+	m.mu.RUnlock()
+	if !ok {
+		return false
 	}
+	buf.mu.Lock()
+	buf.items = append(buf.items, replayBufferedLocation{loc: loc, msg: msg})
+	buf.mu.Unlock()
+	return true
+}
+
+// endReplay stops buffering c's live deliveries and flushes whatever
+// accumulated while the replay scan (up through watermark) was running,
+// skipping anything the replay itself already delivered so a record
+// appended just before the scan finished is never sent twice.
+func (m *Manager) endReplay(c monitorSink, buf *replayBuffer, watermark uint64) {
+	m.mu.Lock()
+	delete(m.monitorReplaying, c)
 	m.mu.Unlock()
-	ws.Close()
+
+	buf.mu.Lock()
+	items := buf.items
+	buf.mu.Unlock()
+
+	for _, it := range items {
+		if it.loc._seq != 0 && it.loc._seq <= watermark {
+			continue // already delivered by the replay scan
+		}
+		if !c.tryWriteLocation(it.loc, it.msg) {
+			closeSlow("monitor", c)
+			m.removeMonitor(c)
+			return
+		}
+	}
 }
 
 // --- HTTP / WS handlers below ---
@@ -205,14 +393,28 @@ func (m *Manager) wsDriverHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	claims, err := m.auth.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := m.auth.AuthorizeDriver(claims, driverID); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("driver ws upgrade failed: %v", err)
 		return
 	}
 	defer ws.Close()
+	configureKeepalive(ws)
 
-	m.addDriverConn(driverID, ws)
+	c := newClient(ws, driverID)
+	go c.writePump()
+
+	m.addDriverConn(driverID, c)
 	defer m.removeDriverConn(driverID)
 	log.Printf("driver connected: %s", driverID)
 
@@ -259,62 +461,176 @@ func (m *Manager) wsDriverHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// WebSocket endpoint for monitors: /ws/monitor?filter_driver=ID
-// Optionally monitors can send "subscribe:<driver_id>" text to subscribe.
+// WebSocket endpoint for monitors: /ws/monitor?filter_driver=ID or
+// ?bbox=minLat,minLng,maxLat,maxLng or ?near=lat,lng,radiusMeters.
+// Monitors can also switch their subscription after connecting by sending
+// "subscribe:<driver_id>", "subscribe_bbox:<bbox>", or "subscribe_near:<near>".
 func (m *Manager) wsMonitorHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
-	filter := query.Get("filter_driver") // optional
+	mf, err := monitorFilterFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, err := m.auth.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := m.auth.AuthorizeMonitor(claims); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if mf.DriverID != "" && !allowedDriversContain(claims.AllowedDrivers, mf.DriverID) {
+		http.Error(w, fmt.Sprintf("auth: token is not authorized for driver %q", mf.DriverID), http.StatusForbidden)
+		return
+	}
+
+	var sinceSeq uint64
+	var sinceTS float64
+	hasSince := false
+	if raw := query.Get("since_seq"); raw != "" {
+		if v, err := strconv.ParseUint(raw, 10, 64); err == nil {
+			sinceSeq, hasSince = v, true
+		}
+	} else if raw := query.Get("since_ts"); raw != "" {
+		if v, err := strconv.ParseFloat(raw, 64); err == nil {
+			sinceTS, hasSince = v, true
+		}
+	}
 
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("monitor ws upgrade failed: %v", err)
 		return
 	}
-	// add to monitors
-	m.addMonitor(ws, filter)
-	log.Printf("monitor connected (filter=%s)", filter)
-
-	// send an initial snapshot of last-known (filtered)
-	if filter != "" {
-		if loc, ok := m.getLastKnown(filter); ok {
-			msg, _ := json.Marshal(loc)
-			ws.WriteMessage(websocket.TextMessage, msg)
+	configureKeepalive(ws)
+
+	c := newClient(ws, "")
+	c.allowedDrivers = claims.AllowedDrivers
+	go c.writePump()
+
+	log.Printf("monitor connected (filter=%+v)", mf)
+
+	// resume mode: replay stored updates from the WAL, then switch to live
+	// tail. c is registered for live fan-out before the replay scan starts
+	// (via beginReplay) so no update appended while the scan is running can
+	// be missed; RunBroadcaster buffers those instead of writing them
+	// straight to c, and endReplay flushes the buffer once the scan
+	// finishes, deduping anything the scan already delivered. This keeps the
+	// two streams from interleaving onto c.send out of order while closing
+	// the gap between the scan finishing and addMonitor registering.
+	if hasSince {
+		buf := m.beginReplay(c)
+		m.addMonitor(c, mf)
+		watermark := m.replayStoredUpdates(c, mf.DriverID, sinceSeq, sinceTS, hasSince)
+		if watermark < sinceSeq {
+			watermark = sinceSeq
 		}
+		m.endReplay(c, buf, watermark)
 	} else {
-		all := m.getAllLastKnown()
-		if len(all) > 0 {
-			msg, _ := json.Marshal(map[string]interface{}{"type": "snapshot", "locations": all})
-			ws.WriteMessage(websocket.TextMessage, msg)
-		}
+		m.addMonitor(c, mf)
 	}
 
+	// send an initial snapshot of last-known, honoring the filter
+	m.sendSnapshot(c, mf)
+
 	// read loop: listen for subscribe: messages or pings; monitors rarely send large messages
 	for {
 		_, message, err := ws.ReadMessage()
 		if err != nil {
-			m.removeMonitor(ws)
+			m.removeMonitor(c)
 			log.Printf("monitor disconnected")
 			return
 		}
 		txt := string(message)
-		if strings.HasPrefix(txt, "subscribe:") {
-			sub := strings.TrimSpace(strings.TrimPrefix(txt, "subscribe:"))
-			m.mu.Lock()
-			m.monitorSubs[ws] = sub
-			m.mu.Unlock()
-			// send ack
-			ack := map[string]interface{}{"type": "subscribed", "driver_id": sub}
-			if b, err := json.Marshal(ack); err == nil {
-				ws.WriteMessage(websocket.TextMessage, b)
-			}
-			// send last-known for that driver if present
-			if loc, ok := m.getLastKnown(sub); ok {
-				if b, err := json.Marshal(loc); err == nil {
-					ws.WriteMessage(websocket.TextMessage, b)
-				}
-			}
+		newFilter, ok := parseSubscribeCommand(txt)
+		if !ok {
+			continue
+		}
+		if newFilter.DriverID != "" && !c.allowsDriver(newFilter.DriverID) {
+			continue // token's allowed_drivers claim forbids this subscription
+		}
+		m.mu.Lock()
+		if newFilter.isZero() {
+			delete(m.monitorSubs, c)
+		} else {
+			m.monitorSubs[c] = newFilter
+		}
+		m.mu.Unlock()
+
+		ack := map[string]interface{}{"type": "subscribed", "driver_id": newFilter.DriverID}
+		if b, err := json.Marshal(ack); err == nil {
+			c.tryWrite(b)
+		}
+		m.sendSnapshot(c, newFilter)
+	}
+}
+
+// monitorFilterFromQuery builds a monitorFilter from the query params
+// accepted by /ws/monitor and /events/locations: filter_driver, bbox, near.
+func monitorFilterFromQuery(query url.Values) (monitorFilter, error) {
+	if driverID := query.Get("filter_driver"); driverID != "" {
+		return monitorFilter{DriverID: driverID}, nil
+	}
+	if raw := query.Get("bbox"); raw != "" {
+		b, err := parseBBox(raw)
+		if err != nil {
+			return monitorFilter{}, fmt.Errorf("invalid bbox: %w", err)
+		}
+		return monitorFilter{BBox: &b}, nil
+	}
+	if raw := query.Get("near"); raw != "" {
+		n, err := parseNear(raw)
+		if err != nil {
+			return monitorFilter{}, fmt.Errorf("invalid near: %w", err)
+		}
+		return monitorFilter{Near: &n}, nil
+	}
+	return monitorFilter{}, nil
+}
+
+// parseSubscribeCommand recognizes the subscribe:/subscribe_bbox:/subscribe_near:
+// wire commands sent by a connected monitor. ok is false for anything else
+// (e.g. a stray ping payload), which the caller should ignore.
+func parseSubscribeCommand(txt string) (monitorFilter, bool) {
+	switch {
+	case strings.HasPrefix(txt, "subscribe_bbox:"):
+		b, err := parseBBox(strings.TrimSpace(strings.TrimPrefix(txt, "subscribe_bbox:")))
+		if err != nil {
+			return monitorFilter{}, false
+		}
+		return monitorFilter{BBox: &b}, true
+	case strings.HasPrefix(txt, "subscribe_near:"):
+		n, err := parseNear(strings.TrimSpace(strings.TrimPrefix(txt, "subscribe_near:")))
+		if err != nil {
+			return monitorFilter{}, false
+		}
+		return monitorFilter{Near: &n}, true
+	case strings.HasPrefix(txt, "subscribe:"):
+		sub := strings.TrimSpace(strings.TrimPrefix(txt, "subscribe:"))
+		return monitorFilter{DriverID: sub}, true
+	default:
+		return monitorFilter{}, false
+	}
+}
+
+// sendSnapshot pushes the current last-known locations matching mf to c, as
+// a single snapshot message (or nothing if none match).
+func (m *Manager) sendSnapshot(c monitorSink, mf monitorFilter) {
+	all := m.getAllLastKnown()
+	matched := make(map[string]Location, len(all))
+	for driverID, loc := range all {
+		if mf.matches(loc) && c.allowsDriver(driverID) {
+			matched[driverID] = loc
 		}
 	}
+	if len(matched) == 0 {
+		return
+	}
+	msg, _ := json.Marshal(map[string]interface{}{"type": "snapshot", "locations": matched})
+	c.tryWrite(msg)
 }
 
 // HTTP POST /location
@@ -329,6 +645,15 @@ func (m *Manager) httpPostLocation(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing driver_id", http.StatusBadRequest)
 		return
 	}
+	claims, err := m.auth.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := m.auth.AuthorizeDriver(claims, payload.DriverID); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if payload.Lat == 0 && payload.Lng == 0 {
 		http.Error(w, "missing lat/lng", http.StatusBadRequest)
 		return
@@ -344,10 +669,26 @@ func (m *Manager) httpPostLocation(w http.ResponseWriter, r *http.Request) {
 
 // GET /locations
 func (m *Manager) httpGetLocations(w http.ResponseWriter, r *http.Request) {
+	claims, err := m.auth.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := m.auth.AuthorizeMonitor(claims); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	all := m.getAllLastKnown()
+	allowed := make(map[string]Location, len(all))
+	for driverID, loc := range all {
+		if allowedDriversContain(claims.AllowedDrivers, driverID) {
+			allowed[driverID] = loc
+		}
+	}
 	out := map[string]interface{}{
-		"count":     len(all),
-		"locations": all,
+		"count":     len(allowed),
+		"locations": allowed,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(out)
@@ -357,6 +698,21 @@ func (m *Manager) httpGetLocations(w http.ResponseWriter, r *http.Request) {
 func (m *Manager) httpGetLocation(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	driverID := vars["driver_id"]
+
+	claims, err := m.auth.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := m.auth.AuthorizeMonitor(claims); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !allowedDriversContain(claims.AllowedDrivers, driverID) {
+		http.Error(w, fmt.Sprintf("auth: token is not authorized for driver %q", driverID), http.StatusForbidden)
+		return
+	}
+
 	loc, ok := m.getLastKnown(driverID)
 	if !ok {
 		http.Error(w, "not found", http.StatusNotFound)
@@ -366,6 +722,125 @@ func (m *Manager) httpGetLocation(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(loc)
 }
 
+// GET /locations/{driver_id}/history?from=<ts>&to=<ts>&limit=N
+func (m *Manager) httpGetLocationHistory(w http.ResponseWriter, r *http.Request) {
+	if m.wal == nil {
+		http.Error(w, "history is unavailable: durable mode is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	vars := mux.Vars(r)
+	driverID := vars["driver_id"]
+
+	claims, err := m.auth.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := m.auth.AuthorizeMonitor(claims); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !allowedDriversContain(claims.AllowedDrivers, driverID) {
+		http.Error(w, fmt.Sprintf("auth: token is not authorized for driver %q", driverID), http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	from, _ := strconv.ParseFloat(query.Get("from"), 64)
+	to, _ := strconv.ParseFloat(query.Get("to"), 64)
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	records, err := m.wal.History(driverID, from, to, limit)
+	if err != nil {
+		http.Error(w, "failed to read history", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"driver_id": driverID,
+		"count":     len(records),
+		"history":   records,
+	})
+}
+
+// replayStoredUpdates streams WAL records matching driverID (empty for all
+// drivers) and the since_seq/since_ts resume point to c, in order, before
+// the caller switches the connection over to live tail. It returns the
+// highest sequence number it actually delivered (0 if none), which the
+// caller uses as the watermark to dedup against buffered live deliveries.
+// It is a no-op when durable mode is disabled or no resume point was given.
+func (m *Manager) replayStoredUpdates(c monitorSink, driverID string, sinceSeq uint64, sinceTS float64, hasSince bool) uint64 {
+	if m.wal == nil || !hasSince {
+		return 0
+	}
+	var lastSeq uint64
+	replay := func(rec wal.Record) bool {
+		if !c.allowsDriver(rec.DriverID) {
+			return true
+		}
+		loc := Location{
+			Type:     "location",
+			DriverID: rec.DriverID,
+			Lat:      rec.Lat,
+			Lng:      rec.Lng,
+			TS:       rec.TS,
+			Meta:     rec.Meta,
+			_seq:     rec.Seq,
+		}
+		msg, _ := json.Marshal(loc)
+		if !c.tryWrite(msg) {
+			return false
+		}
+		lastSeq = rec.Seq
+		return true
+	}
+	if sinceSeq > 0 {
+		_ = m.wal.ReplaySince(sinceSeq, driverID, replay)
+		return lastSeq
+	}
+	_ = m.wal.ReplaySinceTS(sinceTS, driverID, replay)
+	return lastSeq
+}
+
+// clientMetrics is the per-client snapshot exposed by GET /metrics.
+type clientMetrics struct {
+	DriverID   string `json:"driver_id,omitempty"`
+	QueueDepth int    `json:"queue_depth"`
+	Dropped    uint64 `json:"dropped"`
+}
+
+// GET /metrics
+// Reports queue depth and dropped-message counts per connected client, so
+// operators can see which monitors or drivers are falling behind.
+func (m *Manager) httpGetMetrics(w http.ResponseWriter, r *http.Request) {
+	claims, err := m.auth.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := m.auth.AuthorizeMonitor(claims); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	m.mu.RLock()
+	drivers := make([]clientMetrics, 0, len(m.drivers))
+	for driverID, c := range m.drivers {
+		drivers = append(drivers, clientMetrics{DriverID: driverID, QueueDepth: c.queueDepth(), Dropped: c.droppedCount()})
+	}
+	monitors := make([]clientMetrics, 0, len(m.monitors))
+	for c := range m.monitors {
+		monitors = append(monitors, clientMetrics{QueueDepth: c.queueDepth(), Dropped: c.droppedCount()})
+	}
+	m.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"drivers":  drivers,
+		"monitors": monitors,
+	})
+}
+
 func parseFloat(v interface{}) (float64, bool) {
 	if v == nil {
 		return 0, false
@@ -401,25 +876,57 @@ func parseFloat(v interface{}) (float64, bool) {
 
 func main() {
 	var (
-		addr      = flag.String("addr", "0.0.0.0:9000", "listen address")
-		natsURL   = flag.String("nats", os.Getenv("NATS_URL"), "NATS server URL (optional)")
-		natsTopic = flag.String("nats_subject", "drivers.locations", "NATS subject to publish locations")
+		addr          = flag.String("addr", "0.0.0.0:9000", "listen address")
+		brokerURL     = flag.String("broker", os.Getenv("BROKER_URL"), "pub/sub backend URL, e.g. nats://localhost:4222, redis://localhost:6379, or kafka://localhost:9092 (optional; in-memory if unset)")
+		brokerSubject = flag.String("broker_subject", "drivers.locations", "subject/topic used to publish and receive location updates")
+		walDir        = flag.String("wal_dir", os.Getenv("WAL_DIR"), "directory for the durable write-ahead log (optional; durability disabled if unset)")
+		walRetention  = flag.Duration("wal_retention", 24*time.Hour, "how long WAL segments are kept before the compactor drops them")
+		jwtSecret     = flag.String("jwt_secret", os.Getenv("JWT_SECRET"), "HS256 secret for verifying JWTs (optional)")
+		jwtJWKSURL    = flag.String("jwt_jwks_url", os.Getenv("JWT_JWKS_URL"), "JWKS URL for verifying RS256 JWTs (optional)")
+		jwtStatic     = flag.String("jwt_static_tokens", os.Getenv("JWT_STATIC_TOKENS"), "path to a static dev token file (optional)")
 	)
 	flag.Parse()
 
 	manager := NewManager()
 
-	// Optional NATS connect
-	if *natsURL != "" {
-		nc, err := nats.Connect(*natsURL)
+	authenticator, err := auth.New(
+		auth.WithHMACSecret(*jwtSecret),
+		auth.WithJWKSURL(*jwtJWKSURL),
+		auth.WithStaticTokenFile(*jwtStatic),
+	)
+	if err != nil {
+		log.Fatalf("failed to initialize authenticator: %v", err)
+	}
+	manager.SetAuthenticator(authenticator)
+	if authenticator.Enabled() {
+		log.Printf("authentication enabled")
+	} else {
+		log.Printf("authentication not configured; preserving open (no-auth) behavior")
+	}
+
+	if *walDir != "" {
+		w, err := wal.Open(*walDir)
 		if err != nil {
-			log.Printf("warning: failed to connect to NATS at %s: %v", *natsURL, err)
-		} else {
-			manager.SetNATS(nc, *natsTopic)
-			log.Printf("connected to NATS %s, publishing to %s", *natsURL, *natsTopic)
+			log.Fatalf("failed to open WAL at %s: %v", *walDir, err)
 		}
+		manager.SetWAL(w)
+		defer w.Close()
+		log.Printf("durable mode enabled, WAL at %s (retention %s)", *walDir, *walRetention)
+	} else {
+		log.Printf("WAL not configured; running without durability or history")
+	}
+
+	broker, err := messaging.New(*brokerURL)
+	if err != nil {
+		log.Fatalf("failed to initialize broker %q: %v", *brokerURL, err)
+	}
+	if err := manager.SetBroker(broker, *brokerSubject); err != nil {
+		log.Fatalf("failed to subscribe to broker subject %q: %v", *brokerSubject, err)
+	}
+	if *brokerURL == "" {
+		log.Printf("broker not configured; running with in-memory (single-instance) pub/sub")
 	} else {
-		log.Printf("NATS not configured; skipping NATS publishing")
+		log.Printf("connected to broker %s, using subject %s", *brokerURL, *brokerSubject)
 	}
 
 	r := mux.NewRouter()
@@ -431,6 +938,12 @@ func main() {
 	r.HandleFunc("/location", manager.httpPostLocation).Methods("POST")
 	r.HandleFunc("/locations", manager.httpGetLocations).Methods("GET")
 	r.HandleFunc("/locations/{driver_id}", manager.httpGetLocation).Methods("GET")
+	r.HandleFunc("/locations/{driver_id}/history", manager.httpGetLocationHistory).Methods("GET")
+	r.HandleFunc("/metrics", manager.httpGetMetrics).Methods("GET")
+
+	// SSE alternative to /ws/monitor
+	r.HandleFunc("/events/locations", manager.httpEventsLocations).Methods("GET")
+	r.HandleFunc("/events/locations/{driver_id}", manager.httpEventsLocations).Methods("GET")
 
 	server := &http.Server{
 		Addr:    *addr,
@@ -441,6 +954,10 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	go manager.RunBroadcaster(ctx)
 
+	if manager.wal != nil {
+		go wal.RunCompactor(ctx, manager.wal, *walRetention, time.Hour)
+	}
+
 	// graceful shutdown
 	go func() {
 		log.Printf("live location service listening on %s", *addr)
@@ -0,0 +1,97 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// kafkaBroker publishes to and consumes from a Kafka topic, one writer and
+// (per Subscribe call) one reader per broker instance.
+type kafkaBroker struct {
+	brokers        []string
+	defaultSubject string
+	writer         *kafka.Writer
+	cancel         context.CancelFunc
+}
+
+// NewKafka returns a Broker that talks to the Kafka cluster at addr (a
+// comma-separated host:port list) using defaultSubject as the topic when a
+// caller passes an empty subject.
+func NewKafka(addr string, defaultSubject string) (*Broker, error) {
+	brokers := strings.Split(addr, ",")
+	b := &kafkaBroker{
+		brokers:        brokers,
+		defaultSubject: defaultSubject,
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(brokers...),
+			Balancer: &kafka.LeastBytes{},
+		},
+	}
+	return &Broker{Publisher: b, Subscriber: b}, nil
+}
+
+func (b *kafkaBroker) Publish(subject string, loc Location) error {
+	if subject == "" {
+		subject = b.defaultSubject
+	}
+	payload, err := json.Marshal(loc)
+	if err != nil {
+		return err
+	}
+	return b.writer.WriteMessages(context.Background(), kafka.Message{
+		Topic: subject,
+		Key:   []byte(loc.DriverID),
+		Value: payload,
+	})
+}
+
+func (b *kafkaBroker) Subscribe(subject string, handler func(Location)) error {
+	if subject == "" {
+		subject = b.defaultSubject
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: b.brokers,
+		Topic:   subject,
+		GroupID: instanceGroupID(),
+	})
+	go func() {
+		defer reader.Close()
+		for {
+			msg, err := reader.ReadMessage(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+			var loc Location
+			if err := json.Unmarshal(msg.Value, &loc); err != nil {
+				continue
+			}
+			handler(loc)
+		}
+	}()
+	return nil
+}
+
+// instanceGroupID returns a consumer group id unique to this process. Kafka
+// delivers each message to only one member of a group, so a group shared by
+// the whole fleet would let only one instance see any given update; giving
+// every instance its own group makes every instance see every message, like
+// the NATS and Redis backends already do.
+func instanceGroupID() string {
+	return fmt.Sprintf("panaqet-live-location-%s", InstanceID())
+}
+
+func (b *kafkaBroker) Close() error {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	return b.writer.Close()
+}
@@ -0,0 +1,47 @@
+package messaging
+
+import "sync"
+
+// memoryBroker is an in-process Publisher/Subscriber used by tests and
+// single-instance deployments that don't need fleet-wide fan-out. Publish
+// delivers synchronously to all local subscribers of the same subject.
+type memoryBroker struct {
+	mu       sync.RWMutex
+	handlers map[string][]func(Location)
+	closed   bool
+}
+
+// NewMemory returns a Publisher and Subscriber backed by the same in-memory
+// broker; messages published on one are delivered to handlers registered on
+// the other within the same process.
+func NewMemory() (Publisher, Subscriber) {
+	b := &memoryBroker{handlers: make(map[string][]func(Location))}
+	return b, b
+}
+
+func (b *memoryBroker) Publish(subject string, loc Location) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil
+	}
+	for _, h := range b.handlers[subject] {
+		h(loc)
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(subject string, handler func(Location)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[subject] = append(b.handlers[subject], handler)
+	return nil
+}
+
+func (b *memoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.handlers = nil
+	return nil
+}
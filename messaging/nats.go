@@ -0,0 +1,54 @@
+package messaging
+
+import (
+	"encoding/json"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsBroker wraps a *nats.Conn to satisfy Publisher and Subscriber.
+type natsBroker struct {
+	conn *nats.Conn
+	subs []*nats.Subscription
+}
+
+// NewNATS connects to the given NATS URL and returns a Broker backed by it.
+func NewNATS(rawURL string) (*Broker, error) {
+	conn, err := nats.Connect(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	b := &natsBroker{conn: conn}
+	return &Broker{Publisher: b, Subscriber: b}, nil
+}
+
+func (b *natsBroker) Publish(subject string, loc Location) error {
+	payload, err := json.Marshal(loc)
+	if err != nil {
+		return err
+	}
+	return b.conn.Publish(subject, payload)
+}
+
+func (b *natsBroker) Subscribe(subject string, handler func(Location)) error {
+	sub, err := b.conn.Subscribe(subject, func(msg *nats.Msg) {
+		var loc Location
+		if err := json.Unmarshal(msg.Data, &loc); err != nil {
+			return
+		}
+		handler(loc)
+	})
+	if err != nil {
+		return err
+	}
+	b.subs = append(b.subs, sub)
+	return nil
+}
+
+func (b *natsBroker) Close() error {
+	for _, sub := range b.subs {
+		_ = sub.Unsubscribe()
+	}
+	b.conn.Close()
+	return nil
+}
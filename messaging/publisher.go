@@ -0,0 +1,109 @@
+// Package messaging provides a broker-agnostic pub/sub layer used to fan
+// location updates out across a horizontally-scaled fleet of live-location
+// services. Concrete backends (NATS, Redis Streams, Kafka, and an in-memory
+// stub for tests) all satisfy the same Publisher/Subscriber interfaces so
+// the rest of the service only ever depends on this package.
+package messaging
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Location is the wire representation of a driver location update shared
+// across broker implementations. It mirrors (and is kept in sync with) the
+// main package's Location struct. Origin identifies the publishing
+// instance (see InstanceID) so a subscriber can recognize and skip its own
+// messages looped back by the broker.
+type Location struct {
+	Type     string                 `json:"type,omitempty"`
+	DriverID string                 `json:"driver_id"`
+	Lat      float64                `json:"lat"`
+	Lng      float64                `json:"lng"`
+	TS       float64                `json:"ts"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	Origin   string                 `json:"origin,omitempty"`
+}
+
+var (
+	instanceIDOnce sync.Once
+	instanceID     string
+)
+
+// InstanceID returns an identifier unique to this process, stable for its
+// lifetime. It gives every fleet instance its own Kafka consumer group
+// (see kafka.go), and callers can stamp it onto published messages to tell
+// a broker looping their own publish back apart from a genuine update from
+// another instance.
+func InstanceID() string {
+	instanceIDOnce.Do(func() {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown"
+		}
+		instanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	})
+	return instanceID
+}
+
+// Publisher publishes location updates to a subject/topic.
+type Publisher interface {
+	Publish(subject string, loc Location) error
+	Close() error
+}
+
+// Subscriber delivers location updates published (by this or any other
+// instance in the fleet) on a subject/topic to a handler. Implementations
+// are expected to keep calling handler until Close is called.
+type Subscriber interface {
+	Subscribe(subject string, handler func(Location)) error
+	Close() error
+}
+
+// Broker bundles a Publisher and Subscriber sharing the same underlying
+// connection, which is how New constructs them.
+type Broker struct {
+	Publisher
+	Subscriber
+}
+
+// Close closes both the publisher and subscriber sides, returning the first
+// error encountered.
+func (b *Broker) Close() error {
+	if err := b.Publisher.Close(); err != nil {
+		return err
+	}
+	return b.Subscriber.Close()
+}
+
+// New selects a backend by URL scheme (nats://, redis://, kafka://) and
+// returns a ready-to-use Broker. An empty rawURL yields an in-memory broker,
+// which is also the right choice in tests.
+func New(rawURL string) (*Broker, error) {
+	if rawURL == "" {
+		p, s := NewMemory()
+		return &Broker{Publisher: p, Subscriber: s}, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("messaging: invalid broker url %q: %w", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "nats":
+		return NewNATS(rawURL)
+	case "redis":
+		return NewRedis(rawURL)
+	case "kafka":
+		return NewKafka(u.Host, strings.TrimPrefix(u.Path, "/"))
+	case "memory", "mem":
+		p, s := NewMemory()
+		return &Broker{Publisher: p, Subscriber: s}, nil
+	default:
+		return nil, fmt.Errorf("messaging: unknown broker scheme %q (want nats, redis, or kafka)", u.Scheme)
+	}
+}
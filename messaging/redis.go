@@ -0,0 +1,84 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisBroker publishes to and consumes from Redis Streams via XADD/XREAD so
+// that updates survive a brief subscriber outage (unlike Redis Pub/Sub).
+type redisBroker struct {
+	client *redis.Client
+	cancel context.CancelFunc
+}
+
+// NewRedis connects to the given redis:// URL and returns a Broker backed by
+// Redis Streams.
+func NewRedis(rawURL string) (*Broker, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	b := &redisBroker{client: redis.NewClient(opts)}
+	return &Broker{Publisher: b, Subscriber: b}, nil
+}
+
+func (b *redisBroker) Publish(subject string, loc Location) error {
+	payload, err := json.Marshal(loc)
+	if err != nil {
+		return err
+	}
+	return b.client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: subject,
+		MaxLen: 10000,
+		Approx: true,
+		Values: map[string]interface{}{"data": payload},
+	}).Err()
+}
+
+func (b *redisBroker) Subscribe(subject string, handler func(Location)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go func() {
+		lastID := "$" // only new entries; history replay is handled by the WAL, not the broker
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			streams, err := b.client.XRead(ctx, &redis.XReadArgs{
+				Streams: []string{subject, lastID},
+				Block:   0,
+				Count:   100,
+			}).Result()
+			if err != nil {
+				continue
+			}
+			for _, stream := range streams {
+				for _, msg := range stream.Messages {
+					lastID = msg.ID
+					raw, ok := msg.Values["data"].(string)
+					if !ok {
+						continue
+					}
+					var loc Location
+					if err := json.Unmarshal([]byte(raw), &loc); err != nil {
+						continue
+					}
+					handler(loc)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *redisBroker) Close() error {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	return b.client.Close()
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"panaqet/wal"
+)
+
+// sseHeartbeatPeriod is how often a comment line is written to keep an SSE
+// connection (and any intermediate proxy) from timing it out while idle.
+const sseHeartbeatPeriod = 15 * time.Second
+
+// sseClient is the monitorSink implementation for a GET /events/locations
+// connection. It owns a bounded outbound queue exactly like a monitor
+// websocket client, so it is fanned out to by the same broadcaster loop and
+// can never block delivery to anyone else.
+type sseClient struct {
+	send           chan []byte
+	allowedDrivers []string
+	closeOnce      sync.Once
+
+	dropped uint64 // atomic: messages dropped because send was full
+}
+
+func newSSEClient() *sseClient {
+	return &sseClient{
+		send: make(chan []byte, sendBufferSize),
+	}
+}
+
+func (c *sseClient) allowsDriver(driverID string) bool {
+	return allowedDriversContain(c.allowedDrivers, driverID)
+}
+
+func (c *sseClient) queueDepth() int {
+	return len(c.send)
+}
+
+func (c *sseClient) droppedCount() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// tryWrite enqueues msg (an already-JSON-encoded payload, e.g. a snapshot
+// or subscribe ack) as a plain SSE data frame, with no id: line.
+func (c *sseClient) tryWrite(msg []byte) bool {
+	return c.enqueue(sseDataFrame(0, msg))
+}
+
+// tryWriteLocation delivers a broadcast location update, setting id: to the
+// location's WAL sequence number (when durable mode populated one) so a
+// client that drops mid-stream can resume live tailing with Last-Event-ID
+// instead of only being able to resume as of the last replay.
+func (c *sseClient) tryWriteLocation(loc Location, msg []byte) bool {
+	return c.enqueue(sseDataFrame(loc._seq, msg))
+}
+
+func (c *sseClient) enqueue(frame []byte) bool {
+	select {
+	case c.send <- frame:
+		return true
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+		return false
+	}
+}
+
+// sseDataFrame renders msg as one SSE event: an id: line (omitted when seq
+// is 0, i.e. unknown) followed by a data: line and the blank line that
+// terminates the event.
+func sseDataFrame(seq uint64, msg []byte) []byte {
+	if seq == 0 {
+		return []byte(fmt.Sprintf("data: %s\n\n", msg))
+	}
+	return []byte(fmt.Sprintf("id: %d\ndata: %s\n\n", seq, msg))
+}
+
+func (c *sseClient) closeSend() {
+	c.closeOnce.Do(func() { close(c.send) })
+}
+
+// httpEventsLocations serves GET /events/locations and
+// GET /events/locations/{driver_id} as Server-Sent Events: a read-only
+// alternative to the monitor websocket for clients that don't need to send
+// subscribe commands. It accepts the same filter_driver/bbox/near query
+// params as /ws/monitor, registers as a monitor sink so it shares the
+// broadcaster's fan-out and authorization logic, and resumes from the WAL
+// via Last-Event-ID (or since_seq/since_ts) when durable mode is enabled.
+func (m *Manager) httpEventsLocations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	mf, err := monitorFilterFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if driverID := mux.Vars(r)["driver_id"]; driverID != "" {
+		mf = monitorFilter{DriverID: driverID}
+	}
+
+	claims, err := m.auth.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := m.auth.AuthorizeMonitor(claims); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if mf.DriverID != "" && !allowedDriversContain(claims.AllowedDrivers, mf.DriverID) {
+		http.Error(w, fmt.Sprintf("auth: token is not authorized for driver %q", mf.DriverID), http.StatusForbidden)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var sinceSeq uint64
+	var sinceTS float64
+	hasSince := false
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if v, err := strconv.ParseUint(id, 10, 64); err == nil {
+			sinceSeq, hasSince = v, true
+		}
+	} else if raw := query.Get("since_seq"); raw != "" {
+		if v, err := strconv.ParseUint(raw, 10, 64); err == nil {
+			sinceSeq, hasSince = v, true
+		}
+	} else if raw := query.Get("since_ts"); raw != "" {
+		if v, err := strconv.ParseFloat(raw, 64); err == nil {
+			sinceTS, hasSince = v, true
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c := newSSEClient()
+	c.allowedDrivers = claims.AllowedDrivers
+	log.Printf("sse monitor connected (filter=%+v)", mf)
+
+	// Replay writes directly to w, bypassing c.send, so an older stored
+	// record is never reordered behind a live frame. c is registered for
+	// live fan-out before the replay scan starts (via beginReplay) so an
+	// update appended while the scan is running is never lost;
+	// RunBroadcaster holds those in the replay buffer instead of c.send, and endReplay flushes
+	// the buffer once the scan finishes, deduping anything already written
+	// to w by the scan.
+	if hasSince {
+		buf := m.beginReplay(c)
+		m.addMonitor(c, mf)
+		watermark, err := m.writeSSEReplay(w, c, mf.DriverID, sinceSeq, sinceTS)
+		if err != nil {
+			m.endReplay(c, buf, watermark)
+			m.removeMonitor(c)
+			return
+		}
+		flusher.Flush()
+		if watermark < sinceSeq {
+			watermark = sinceSeq
+		}
+		m.endReplay(c, buf, watermark)
+	} else {
+		m.addMonitor(c, mf)
+	}
+	defer m.removeMonitor(c)
+	m.sendSnapshot(c, mf)
+
+	notify := r.Context().Done()
+	heartbeat := time.NewTicker(sseHeartbeatPeriod)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-notify:
+			return
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case frame, ok := <-c.send:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSEReplay streams WAL records matching driverID (empty for all
+// drivers) from the since_seq/since_ts resume point directly to w, using
+// each record's WAL sequence number as the SSE event id so a client can
+// resume again with Last-Event-ID. Unlike live broadcast frames it bypasses
+// c.send, so an older stored record is never queued behind a live frame.
+// It returns the highest sequence number actually written (0 if none),
+// which the caller uses as the watermark to dedup against buffered live
+// deliveries.
+func (m *Manager) writeSSEReplay(w http.ResponseWriter, c *sseClient, driverID string, sinceSeq uint64, sinceTS float64) (uint64, error) {
+	if m.wal == nil {
+		return 0, nil
+	}
+	var writeErr error
+	var lastSeq uint64
+	replay := func(rec wal.Record) bool {
+		if !c.allowsDriver(rec.DriverID) {
+			return true
+		}
+		loc := Location{
+			Type:     "location",
+			DriverID: rec.DriverID,
+			Lat:      rec.Lat,
+			Lng:      rec.Lng,
+			TS:       rec.TS,
+			Meta:     rec.Meta,
+		}
+		msg, err := json.Marshal(loc)
+		if err != nil {
+			return true
+		}
+		if _, err := w.Write(sseDataFrame(rec.Seq, msg)); err != nil {
+			writeErr = err
+			return false
+		}
+		lastSeq = rec.Seq
+		return true
+	}
+	if sinceSeq > 0 {
+		if err := m.wal.ReplaySince(sinceSeq, driverID, replay); err != nil {
+			return lastSeq, err
+		}
+	} else {
+		if err := m.wal.ReplaySinceTS(sinceTS, driverID, replay); err != nil {
+			return lastSeq, err
+		}
+	}
+	return lastSeq, writeErr
+}
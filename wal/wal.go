@@ -0,0 +1,264 @@
+// Package wal implements a durable, segmented, append-only write-ahead log
+// of driver location updates. It lets the live-location service survive
+// restarts without losing recent history and backs the HTTP history
+// endpoint and the WebSocket resume mode.
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Record is a single persisted location fix. Seq is a monotonically
+// increasing, WAL-wide sequence number assigned at Append time; DriverID
+// scopes the fix to a driver so history can be filtered per driver.
+type Record struct {
+	Seq      uint64                 `json:"seq"`
+	DriverID string                 `json:"driver_id"`
+	Lat      float64                `json:"lat"`
+	Lng      float64                `json:"lng"`
+	TS       float64                `json:"ts"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+}
+
+// defaultSegmentSize is the approximate size, in bytes, at which the WAL
+// rotates to a new segment file.
+const defaultSegmentSize = 16 * 1024 * 1024
+
+// WAL is a directory of segment files holding an ordered log of Records.
+// It is safe for concurrent use.
+type WAL struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64
+	nextSeq     uint64
+
+	active       *segment
+	activeSize   int64
+	segmentIndex []segmentMeta // ascending by FirstSeq, includes the active segment
+}
+
+// segmentMeta records the sequence range covered by a segment file, used to
+// pick which files to scan for a given replay request without opening them
+// all.
+type segmentMeta struct {
+	path     string
+	firstSeq uint64
+	lastSeq  uint64
+}
+
+// Open opens (creating if necessary) a WAL rooted at dir, replaying its
+// segment index so Append can continue the sequence where it left off.
+func Open(dir string) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("wal: creating directory %s: %w", dir, err)
+	}
+	w := &WAL{dir: dir, segmentSize: defaultSegmentSize}
+	if err := w.loadIndex(); err != nil {
+		return nil, err
+	}
+	if w.active == nil {
+		if err := w.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// loadIndex scans existing segment files (if any) to rebuild segmentIndex
+// and nextSeq, and reopens the most recent one for appending.
+func (w *WAL) loadIndex() error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && filepath.Ext(e.Name()) == segmentExt {
+			paths = append(paths, filepath.Join(w.dir, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		first, last, err := scanSegmentRange(p)
+		if err != nil {
+			return fmt.Errorf("wal: scanning segment %s: %w", p, err)
+		}
+		w.segmentIndex = append(w.segmentIndex, segmentMeta{path: p, firstSeq: first, lastSeq: last})
+		if last > w.nextSeq {
+			w.nextSeq = last
+		}
+	}
+
+	if len(paths) > 0 {
+		last := paths[len(paths)-1]
+		seg, size, err := openSegmentForAppend(last)
+		if err != nil {
+			return err
+		}
+		w.active = seg
+		w.activeSize = size
+	}
+	return nil
+}
+
+// Append persists rec, assigning it the next sequence number, and returns
+// the record as actually written (with Seq populated). Append fsyncs the
+// record to disk before returning, and must complete before the caller
+// enqueues the update for broadcast, so that a crash between the two never
+// loses an update that was already fanned out, and a crash right after
+// Append returns never loses one that was only sitting in the OS page
+// cache.
+func (w *WAL) Append(rec Record) (Record, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec.Seq = w.nextSeq + 1
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return rec, err
+	}
+	b = append(b, '\n')
+
+	// rotate before advancing nextSeq, so the new segment is named after
+	// rec.Seq, the first record it will hold
+	if w.activeSize+int64(len(b)) > w.segmentSize {
+		if err := w.rotate(); err != nil {
+			return rec, err
+		}
+	}
+	w.nextSeq = rec.Seq
+
+	n, err := w.active.write(b)
+	if err != nil {
+		return rec, err
+	}
+	w.activeSize += int64(n)
+	if err := w.active.sync(); err != nil {
+		return rec, fmt.Errorf("wal: syncing append of seq %d: %w", rec.Seq, err)
+	}
+	w.updateActiveRange(rec.Seq)
+	return rec, nil
+}
+
+func (w *WAL) updateActiveRange(seq uint64) {
+	if len(w.segmentIndex) == 0 || w.segmentIndex[len(w.segmentIndex)-1].path != w.active.path {
+		w.segmentIndex = append(w.segmentIndex, segmentMeta{path: w.active.path, firstSeq: seq, lastSeq: seq})
+		return
+	}
+	last := &w.segmentIndex[len(w.segmentIndex)-1]
+	if last.firstSeq == 0 {
+		last.firstSeq = seq
+	}
+	last.lastSeq = seq
+}
+
+// rotate closes the current active segment (if any) and starts a new one.
+// Caller must hold w.mu.
+func (w *WAL) rotate() error {
+	if w.active != nil {
+		if err := w.active.close(); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(w.dir, segmentFileName(w.nextSeq+1))
+	seg, err := createSegment(path)
+	if err != nil {
+		return err
+	}
+	w.active = seg
+	w.activeSize = 0
+	return nil
+}
+
+// Close flushes and closes the active segment.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.active == nil {
+		return nil
+	}
+	return w.active.close()
+}
+
+// ReplaySince calls fn for every record with Seq > sinceSeq, in order,
+// across all segments. If driverID is non-empty, only that driver's
+// records are delivered. fn returning false stops replay early.
+func (w *WAL) ReplaySince(sinceSeq uint64, driverID string, fn func(Record) bool) error {
+	w.mu.Lock()
+	segments := make([]segmentMeta, len(w.segmentIndex))
+	copy(segments, w.segmentIndex)
+	w.mu.Unlock()
+
+	for _, sm := range segments {
+		if sm.lastSeq <= sinceSeq {
+			continue
+		}
+		cont, err := scanSegment(sm.path, func(r Record) bool {
+			if r.Seq <= sinceSeq {
+				return true
+			}
+			if driverID != "" && r.DriverID != driverID {
+				return true
+			}
+			return fn(r)
+		})
+		if err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+	}
+	return nil
+}
+
+// ReplaySinceTS calls fn for every record with TS > sinceTS, in order,
+// across all segments. If driverID is non-empty, only that driver's
+// records are delivered. fn returning false stops replay early.
+func (w *WAL) ReplaySinceTS(sinceTS float64, driverID string, fn func(Record) bool) error {
+	return w.ReplaySince(0, driverID, func(r Record) bool {
+		if r.TS <= sinceTS {
+			return true
+		}
+		return fn(r)
+	})
+}
+
+// History returns driverID's records with TS in [fromTS, toTS] (either bound
+// may be zero to mean unbounded), newest-limited to at most limit records
+// (0 means unlimited), in the order they were appended (ascending Seq).
+func (w *WAL) History(driverID string, fromTS, toTS float64, limit int) ([]Record, error) {
+	var out []Record
+	err := w.ReplaySince(0, driverID, func(r Record) bool {
+		if fromTS != 0 && r.TS < fromTS {
+			return true
+		}
+		if toTS != 0 && r.TS > toTS {
+			return true
+		}
+		out = append(out, r)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out, nil
+}
+
+// newBufReader is a small helper shared by segment scanning code.
+func newBufReader(f *os.File) *bufio.Scanner {
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return s
+}
@@ -0,0 +1,165 @@
+package wal
+
+import (
+	"testing"
+)
+
+func mustOpen(t *testing.T) *WAL {
+	t.Helper()
+	w, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+func TestAppendAssignsIncreasingSeq(t *testing.T) {
+	w := mustOpen(t)
+
+	r1, err := w.Append(Record{DriverID: "d1", Lat: 1, Lng: 1, TS: 10})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	r2, err := w.Append(Record{DriverID: "d1", Lat: 2, Lng: 2, TS: 20})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if r1.Seq == 0 || r2.Seq != r1.Seq+1 {
+		t.Fatalf("expected strictly increasing sequence numbers, got %d then %d", r1.Seq, r2.Seq)
+	}
+}
+
+func TestReplaySinceFiltersAndOrders(t *testing.T) {
+	w := mustOpen(t)
+
+	var seqs []uint64
+	for i, rec := range []Record{
+		{DriverID: "d1", Lat: 1, Lng: 1, TS: 1},
+		{DriverID: "d2", Lat: 2, Lng: 2, TS: 2},
+		{DriverID: "d1", Lat: 3, Lng: 3, TS: 3},
+		{DriverID: "d1", Lat: 4, Lng: 4, TS: 4},
+	} {
+		got, err := w.Append(rec)
+		if err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+		seqs = append(seqs, got.Seq)
+	}
+
+	var replayed []Record
+	if err := w.ReplaySince(seqs[0], "d1", func(r Record) bool {
+		replayed = append(replayed, r)
+		return true
+	}); err != nil {
+		t.Fatalf("ReplaySince: %v", err)
+	}
+
+	if len(replayed) != 2 {
+		t.Fatalf("expected 2 records after seq %d for d1, got %d: %+v", seqs[0], len(replayed), replayed)
+	}
+	for i, r := range replayed {
+		if r.DriverID != "d1" {
+			t.Errorf("record %d: expected driver d1, got %s", i, r.DriverID)
+		}
+		if r.Seq <= seqs[0] {
+			t.Errorf("record %d: expected seq > %d, got %d", i, seqs[0], r.Seq)
+		}
+	}
+	if replayed[0].Seq >= replayed[1].Seq {
+		t.Errorf("expected replay in ascending seq order, got %d then %d", replayed[0].Seq, replayed[1].Seq)
+	}
+
+	var earlyStop []Record
+	if err := w.ReplaySince(0, "", func(r Record) bool {
+		earlyStop = append(earlyStop, r)
+		return false
+	}); err != nil {
+		t.Fatalf("ReplaySince (early stop): %v", err)
+	}
+	if len(earlyStop) != 1 {
+		t.Fatalf("expected replay to stop after the first record when fn returns false, got %d", len(earlyStop))
+	}
+}
+
+func TestHistoryFiltersByTimeRangeAndLimit(t *testing.T) {
+	w := mustOpen(t)
+
+	for _, rec := range []Record{
+		{DriverID: "d1", Lat: 1, Lng: 1, TS: 10},
+		{DriverID: "d1", Lat: 2, Lng: 2, TS: 20},
+		{DriverID: "d1", Lat: 3, Lng: 3, TS: 30},
+		{DriverID: "d2", Lat: 4, Lng: 4, TS: 25},
+	} {
+		if _, err := w.Append(rec); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	all, err := w.History("d1", 0, 0, 0)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected 3 records for d1, got %d", len(all))
+	}
+
+	ranged, err := w.History("d1", 15, 25, 0)
+	if err != nil {
+		t.Fatalf("History (ranged): %v", err)
+	}
+	if len(ranged) != 1 || ranged[0].TS != 20 {
+		t.Fatalf("expected exactly the TS=20 record, got %+v", ranged)
+	}
+
+	limited, err := w.History("d1", 0, 0, 2)
+	if err != nil {
+		t.Fatalf("History (limited): %v", err)
+	}
+	if len(limited) != 2 {
+		t.Fatalf("expected limit=2 to return 2 records, got %d", len(limited))
+	}
+	if limited[0].TS != 20 || limited[1].TS != 30 {
+		t.Fatalf("expected the newest 2 records in ascending order, got %+v", limited)
+	}
+}
+
+func TestReplayResumesAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	first, err := w.Append(Record{DriverID: "d1", Lat: 1, Lng: 1, TS: 1})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	w2, err := Open(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer w2.Close()
+
+	second, err := w2.Append(Record{DriverID: "d1", Lat: 2, Lng: 2, TS: 2})
+	if err != nil {
+		t.Fatalf("Append after reopen: %v", err)
+	}
+	if second.Seq != first.Seq+1 {
+		t.Fatalf("expected sequence to continue after reopen: first=%d second=%d", first.Seq, second.Seq)
+	}
+
+	var all []Record
+	if err := w2.ReplaySince(0, "", func(r Record) bool {
+		all = append(all, r)
+		return true
+	}); err != nil {
+		t.Fatalf("ReplaySince: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected both pre- and post-reopen records to replay, got %d", len(all))
+	}
+}